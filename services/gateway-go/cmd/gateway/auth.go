@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// Claims is the subset of a verified token's claims the gateway cares about.
+type Claims struct {
+	Subject string
+	Scopes  []string
+	Tenant  string
+	Expiry  time.Time
+}
+
+// HasScope reports whether the claims grant the given scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenVerifier verifies a bearer token and returns its claims.
+type TokenVerifier interface {
+	Verify(tokenString string) (*Claims, error)
+}
+
+// hs256Verifier verifies tokens signed with a shared HS256 secret.
+type hs256Verifier struct {
+	secret []byte
+}
+
+func newHS256Verifier(secret string) *hs256Verifier {
+	return &hs256Verifier{secret: []byte(secret)}
+}
+
+func (v *hs256Verifier) Verify(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return v.secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimsFromToken(token)
+}
+
+// jwksVerifier verifies RS256/ES256 tokens against keys published at a JWKS
+// endpoint. Keys are cached by kid and refreshed on a cache miss; concurrent
+// misses for the same kid are collapsed via singleflight so a burst of
+// requests for a just-rotated key only triggers one refresh.
+type jwksVerifier struct {
+	url    string
+	client *http.Client
+
+	mu   sync.RWMutex
+	keys map[string]any
+
+	group singleflight.Group
+}
+
+func newJWKSVerifier(url string, client *http.Client) *jwksVerifier {
+	return &jwksVerifier{
+		url:    url,
+		client: client,
+		keys:   make(map[string]any),
+	}
+}
+
+func (v *jwksVerifier) Verify(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(t *jwt.Token) (any, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, fmt.Errorf("token missing kid")
+		}
+		return v.keyFor(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimsFromToken(token)
+}
+
+func (v *jwksVerifier) keyFor(kid string) (any, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	v.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	result, err, _ := v.group.Do(kid, func() (any, error) {
+		if err := v.refresh(); err != nil {
+			return nil, err
+		}
+		v.mu.RLock()
+		defer v.mu.RUnlock()
+		key, ok := v.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+func (v *jwksVerifier) refresh() error {
+	resp, err := v.client.Get(v.url)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := parseJWK(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+	return nil
+}
+
+func parseJWK(k jwk) (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decode n: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decode e: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decode x: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decode y: %w", err)
+		}
+		curve, err := ecCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func ecCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+}
+
+// multiVerifier dispatches to an algorithm-specific verifier based on the
+// token's (unverified) header, rejecting algorithms that aren't explicitly
+// wired up. This is what protects against algorithm-confusion attacks: a
+// token can't opt itself into a verifier it wasn't issued for.
+type multiVerifier struct {
+	hs256 TokenVerifier
+	jwks  TokenVerifier
+}
+
+func (m *multiVerifier) Verify(tokenString string) (*Claims, error) {
+	alg, err := peekAlg(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	switch alg {
+	case "HS256":
+		if m.hs256 == nil {
+			return nil, fmt.Errorf("algorithm %q is not accepted", alg)
+		}
+		return m.hs256.Verify(tokenString)
+	case "RS256", "ES256":
+		if m.jwks == nil {
+			return nil, fmt.Errorf("algorithm %q is not accepted", alg)
+		}
+		return m.jwks.Verify(tokenString)
+	default:
+		return nil, fmt.Errorf("algorithm %q is not accepted", alg)
+	}
+}
+
+func peekAlg(tokenString string) (string, error) {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return "", fmt.Errorf("parse token header: %w", err)
+	}
+	return token.Method.Alg(), nil
+}
+
+func claimsFromToken(token *jwt.Token) (*Claims, error) {
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("unexpected claims type")
+	}
+
+	claims := &Claims{
+		Subject: stringClaim(mapClaims, "sub"),
+		Tenant:  stringClaim(mapClaims, "tenant"),
+	}
+	if exp, err := mapClaims.GetExpirationTime(); err == nil && exp != nil {
+		claims.Expiry = exp.Time
+	}
+	switch scope := mapClaims["scope"].(type) {
+	case string:
+		claims.Scopes = strings.Fields(scope)
+	case []any:
+		for _, s := range scope {
+			if str, ok := s.(string); ok {
+				claims.Scopes = append(claims.Scopes, str)
+			}
+		}
+	}
+	return claims, nil
+}
+
+func stringClaim(claims jwt.MapClaims, key string) string {
+	if v, ok := claims[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// buildVerifier wires up the verifier(s) configured via env. It returns nil
+// if neither HS256 nor JWKS verification is configured, meaning auth is
+// disabled (handled by the caller, matching the previous JWTSecret=="" behavior).
+func buildVerifier(cfg config, httpClient *http.Client) TokenVerifier {
+	var hs256 TokenVerifier
+	if cfg.JWTSecret != "" {
+		hs256 = newHS256Verifier(cfg.JWTSecret)
+	}
+	var jwks TokenVerifier
+	if cfg.JWKSURL != "" {
+		jwks = newJWKSVerifier(cfg.JWKSURL, httpClient)
+	}
+	if hs256 == nil && jwks == nil {
+		return nil
+	}
+	return &multiVerifier{hs256: hs256, jwks: jwks}
+}
+
+// withClaims returns a context carrying the verified claims.
+func withClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// claimsFromContext retrieves the claims stored by withClaims, if any.
+func claimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok
+}
+
+// setUserHeaders forwards verified identity to the upstream inference
+// service so it doesn't need to re-parse or trust the raw bearer token.
+func setUserHeaders(proxyReq *http.Request, claims *Claims) {
+	if claims == nil {
+		return
+	}
+	if claims.Subject != "" {
+		proxyReq.Header.Set("X-User-Id", claims.Subject)
+	}
+	if len(claims.Scopes) > 0 {
+		proxyReq.Header.Set("X-User-Scopes", strings.Join(claims.Scopes, " "))
+	}
+	if claims.Tenant != "" {
+		proxyReq.Header.Set("X-Tenant-Id", claims.Tenant)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestHS256VerifierAccepts(t *testing.T) {
+	v := newHS256Verifier("secret")
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   "user-1",
+		"scope": "chat:write chat:read",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	claims, err := v.Verify(signed)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if claims.Subject != "user-1" || !claims.HasScope("chat:write") {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestHS256VerifierRejectsExpired(t *testing.T) {
+	v := newHS256Verifier("secret")
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	signed, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if _, err := v.Verify(signed); err == nil {
+		t.Fatalf("expected expired token to be rejected")
+	}
+}
+
+func newRSAJWKSServer(t *testing.T, key *rsa.PrivateKey, kid string) *httptest.Server {
+	t.Helper()
+	set := jwkSet{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: kid,
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+	}}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+}
+
+func TestJWKSVerifierRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	server := newRSAJWKSServer(t, key, "kid-1")
+	defer server.Close()
+
+	v := newJWKSVerifier(server.URL, server.Client())
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "user-2",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "kid-1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	claims, err := v.Verify(signed)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if claims.Subject != "user-2" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestJWKSVerifierMissingKid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	server := newRSAJWKSServer(t, key, "kid-1")
+	defer server.Close()
+
+	v := newJWKSVerifier(server.URL, server.Client())
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "user-2"})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if _, err := v.Verify(signed); err == nil {
+		t.Fatalf("expected missing kid to be rejected")
+	}
+}
+
+func TestJWKSVerifierRefreshesOnUnknownKid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	var fetches int
+	set := jwkSet{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: "kid-2",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+	}}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	defer server.Close()
+
+	v := newJWKSVerifier(server.URL, server.Client())
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "user-3",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "kid-2"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	if _, err := v.Verify(signed); err != nil {
+		t.Fatalf("first verify should trigger a refresh: %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("expected exactly one fetch, got %d", fetches)
+	}
+	if _, err := v.Verify(signed); err != nil {
+		t.Fatalf("second verify should use the cached key: %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("expected no additional fetch on cache hit, got %d", fetches)
+	}
+}
+
+func TestMultiVerifierRejectsUnexpectedAlg(t *testing.T) {
+	m := &multiVerifier{hs256: newHS256Verifier("secret")}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": "user-4"})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	if _, err := m.Verify(signed); err == nil {
+		t.Fatalf("expected ES256 to be rejected when no JWKS verifier is configured")
+	}
+}
+
+func TestMultiVerifierSwitchesByAlgorithm(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	server := newRSAJWKSServer(t, key, "kid-5")
+	defer server.Close()
+
+	m := &multiVerifier{
+		hs256: newHS256Verifier("secret"),
+		jwks:  newJWKSVerifier(server.URL, server.Client()),
+	}
+
+	hsToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "hs-user"})
+	hsSigned, err := hsToken.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign hs256: %v", err)
+	}
+	if claims, err := m.Verify(hsSigned); err != nil || claims.Subject != "hs-user" {
+		t.Fatalf("expected HS256 token to verify via hs256 path, got claims=%+v err=%v", claims, err)
+	}
+
+	rsToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "rs-user"})
+	rsToken.Header["kid"] = "kid-5"
+	rsSigned, err := rsToken.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign rs256: %v", err)
+	}
+	if claims, err := m.Verify(rsSigned); err != nil || claims.Subject != "rs-user" {
+		t.Fatalf("expected RS256 token to verify via jwks path, got claims=%+v err=%v", claims, err)
+	}
+}
+
+func TestPeekAlgRejectsGarbage(t *testing.T) {
+	if _, err := peekAlg("not-a-jwt"); err == nil {
+		t.Fatalf("expected error for malformed token")
+	}
+}
+
@@ -2,22 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 )
 
 const appVersion = "0.1.0"
@@ -41,74 +43,99 @@ var (
 )
 
 type config struct {
-	InferenceBaseURL string
-	JWTSecret        string
-	RateLimitRPS     int
-	Port             string
+	InferenceBaseURL  string
+	JWTSecret         string
+	JWKSURL           string
+	ChatRequireScope  string
+	RateLimitChat     string
+	RateLimitBurst    int
+	RateLimitMaxKeys  int
+	RedisAddr         string
+	RetryMaxAttempts  int
+	RetryBaseDelayMs  int
+	RetryMaxDelayMs   int
+	HedgeDelayMs      int
+	OTelEndpoint      string
+	GatewayConfigPath string
+	AdminRequireScope string
+	Port              string
 }
 
-type rateLimiter struct {
-	mu    sync.Mutex
-	limit int
-	state map[string]*rateState
-}
-
-type rateState struct {
-	second int64
-	count  int
-}
-
-func newRateLimiter(limit int) *rateLimiter {
-	return &rateLimiter{
-		limit: limit,
-		state: make(map[string]*rateState),
-	}
-}
-
-func (rl *rateLimiter) Allow(ip string) bool {
-	if rl.limit <= 0 {
-		return true
-	}
-	if ip == "" {
-		ip = "unknown"
-	}
-	now := time.Now().Unix()
-
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	entry, ok := rl.state[ip]
-	if !ok || entry.second != now {
-		rl.state[ip] = &rateState{second: now, count: 1}
-		return true
+// buildLimiter wires up the configured rate limiter backend: Redis-backed
+// sliding window when REDIS_ADDR is set (so multiple gateway pods share
+// state), otherwise an in-memory token bucket per key.
+func buildLimiter(cfg config) (Limiter, error) {
+	spec, err := parseRateSpec(cfg.RateLimitChat, cfg.RateLimitBurst)
+	if err != nil {
+		return nil, err
 	}
-
-	if entry.count >= rl.limit {
-		return false
+	if cfg.RedisAddr != "" {
+		client := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+		return newRedisLimiter(client, spec), nil
 	}
-	entry.count++
-	return true
+	return newMemoryLimiter(spec, cfg.RateLimitMaxKeys)
 }
 
 func main() {
-	log.SetFlags(0)
+	slog.SetDefault(newLogger())
 
 	prometheus.MustRegister(requestCount, requestLatency)
 
 	cfg := loadConfig()
-	if cfg.JWTSecret == "" {
-		logJSON("warn", "JWT_SECRET not set; all requests are allowed", nil)
+	ctx := context.Background()
+
+	tel, err := initTracing(ctx, cfg)
+	if err != nil {
+		slog.Error("failed to initialize tracing", "error", err.Error())
+		os.Exit(1)
 	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := tel.Shutdown(shutdownCtx); err != nil {
+			slog.Error("tracer shutdown failed", "error", err.Error())
+		}
+	}()
 
-	ml := newRateLimiter(cfg.RateLimitRPS)
+	ml, err := buildLimiter(cfg)
+	if err != nil {
+		slog.Error("failed to build rate limiter", "error", err.Error())
+		os.Exit(1)
+	}
 	client := &http.Client{Timeout: 30 * time.Second}
+	streamClient := &http.Client{} // no Timeout: lifetime is bounded by the request context instead
+	instrumentClient(client)
+	instrumentClient(streamClient)
+	upstream := newUpstreamClient(client, retryPolicy{
+		maxAttempts: cfg.RetryMaxAttempts,
+		baseDelay:   time.Duration(cfg.RetryBaseDelayMs) * time.Millisecond,
+		maxDelay:    time.Duration(cfg.RetryMaxDelayMs) * time.Millisecond,
+	}, time.Duration(cfg.HedgeDelayMs)*time.Millisecond)
+
+	verifier := buildVerifier(cfg, client)
+	if verifier == nil {
+		slog.Warn("JWT_SECRET and JWKS_URL not set; all requests are allowed")
+	}
+
+	var router *Router
+	if cfg.GatewayConfigPath != "" {
+		router, err = NewRouter(cfg.GatewayConfigPath)
+		if err != nil {
+			slog.Error("failed to load gateway routing config", "error", err.Error())
+			os.Exit(1)
+		}
+		sigHup := make(chan os.Signal, 1)
+		signal.Notify(sigHup, syscall.SIGHUP)
+		go router.watchReload(sigHup)
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/health", healthHandler(tel))
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/v1/chat", chatHandler(cfg, ml, client))
+	mux.HandleFunc("/v1/chat", chatHandler(cfg, ml, upstream, streamClient, verifier, router))
+	mux.HandleFunc("/admin/config", adminConfigHandler(router, verifier, cfg.AdminRequireScope))
 
-	handler := withLogging(withCORS(mux))
+	handler := tel.wrapHandler(withLogging(withCORS(mux)))
 
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
@@ -117,64 +144,102 @@ func main() {
 		WriteTimeout: 60 * time.Second,
 	}
 
-	logJSON("info", "gateway started", map[string]any{"port": cfg.Port})
+	slog.Info("gateway started", "port", cfg.Port)
 	if err := server.ListenAndServe(); err != nil {
-		logJSON("error", "gateway stopped", map[string]any{"error": err.Error()})
+		slog.Error("gateway stopped", "error", err.Error())
 		os.Exit(1)
 	}
 }
 
 func loadConfig() config {
 	return config{
-		InferenceBaseURL: envOrDefault("INFERENCE_BASE_URL", "http://inference:8000"),
-		JWTSecret:        os.Getenv("JWT_SECRET"),
-		RateLimitRPS:     envIntOrDefault("RATE_LIMIT_RPS", 5),
-		Port:             envOrDefault("PORT", "8080"),
+		InferenceBaseURL:  envOrDefault("INFERENCE_BASE_URL", "http://inference:8000"),
+		JWTSecret:         os.Getenv("JWT_SECRET"),
+		JWKSURL:           os.Getenv("JWKS_URL"),
+		ChatRequireScope:  os.Getenv("REQUIRE_SCOPE_CHAT"),
+		RateLimitChat:     envOrDefault("RATE_LIMIT_CHAT", "5/s"),
+		RateLimitBurst:    envIntOrDefault("RATE_LIMIT_BURST", 20),
+		RateLimitMaxKeys:  envIntOrDefault("RATE_LIMIT_MAX_KEYS", 10000),
+		RedisAddr:         os.Getenv("REDIS_ADDR"),
+		RetryMaxAttempts:  envIntOrDefault("RETRY_MAX_ATTEMPTS", 3),
+		RetryBaseDelayMs:  envIntOrDefault("RETRY_BASE_DELAY_MS", 50),
+		RetryMaxDelayMs:   envIntOrDefault("RETRY_MAX_DELAY_MS", 2000),
+		HedgeDelayMs:      envIntOrDefault("HEDGE_DELAY_MS", 0),
+		OTelEndpoint:      os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		GatewayConfigPath: os.Getenv("GATEWAY_CONFIG"),
+		AdminRequireScope: envOrDefault("REQUIRE_SCOPE_ADMIN", "admin:read"),
+		Port:              envOrDefault("PORT", "8080"),
 	}
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+func healthHandler(tel *tracing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":  "ok",
+			"service": "gateway",
+			"version": appVersion,
+			"tracing": tel.status(),
+		})
 	}
-	writeJSON(w, http.StatusOK, map[string]string{
-		"status":  "ok",
-		"service": "gateway",
-		"version": appVersion,
-	})
 }
 
-func chatHandler(cfg config, rl *rateLimiter, client *http.Client) http.HandlerFunc {
+func chatHandler(cfg config, rl Limiter, upstream *upstreamClient, streamClient *http.Client, verifier TokenVerifier, router *Router) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
 
-		ip := clientIP(r)
-		if !rl.Allow(ip) {
-			writeJSON(w, http.StatusTooManyRequests, map[string]string{
-				"error": "rate limit exceeded",
-			})
-			return
-		}
-
-		if cfg.JWTSecret != "" {
-			if err := validateJWT(r, cfg.JWTSecret); err != nil {
+		// Auth runs before rate limiting so the limiter can key off the
+		// authenticated subject rather than only the client IP.
+		var claims *Claims
+		if verifier != nil {
+			tokenString, err := bearerToken(r)
+			if err != nil {
 				writeJSON(w, http.StatusUnauthorized, map[string]string{
 					"error": "unauthorized",
 				})
 				return
 			}
+			claims, err = verifier.Verify(tokenString)
+			if err != nil {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{
+					"error": "unauthorized",
+				})
+				return
+			}
+			if cfg.ChatRequireScope != "" && !claims.HasScope(cfg.ChatRequireScope) {
+				writeJSON(w, http.StatusForbidden, map[string]string{
+					"error": "insufficient scope",
+				})
+				return
+			}
+			r = r.WithContext(withClaims(r.Context(), claims))
+		}
+
+		limitResult, err := rl.Allow(r.Context(), rateLimitKey(r))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "rate limiter unavailable",
+			})
+			return
+		}
+		applyRateLimitHeaders(w, limitResult)
+		if !limitResult.Allowed {
+			throttledTotal.WithLabelValues(r.URL.Path).Inc()
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{
+				"error": "rate limit exceeded",
+			})
+			return
 		}
 
 		requestID := r.Header.Get("X-Request-Id")
 		if requestID == "" {
 			requestID = newUUID()
-			if requestID != "" {
-				r.Header.Set("X-Request-Id", requestID)
-			}
 		}
 
 		body, err := io.ReadAll(r.Body)
@@ -184,8 +249,33 @@ func chatHandler(cfg config, rl *rateLimiter, client *http.Client) http.HandlerF
 			})
 			return
 		}
+		model := requestModel(body)
+		annotateSpan(r.Context(), claims, model, 0)
+
+		// With a routing table configured, pick a (possibly A/B-split)
+		// upstream for the model; otherwise every request goes to the
+		// single configured inference backend.
+		targetURL := cfg.InferenceBaseURL + "/v1/chat"
+		var picked Upstream
+		haveRoute := false
+		if router != nil {
+			if u, err := router.Select(model); err == nil {
+				picked, haveRoute = u, true
+				targetURL = picked.URL
+			} else {
+				slog.WarnContext(r.Context(), "no configured route for model, falling back to default upstream",
+					"model", model, "error", err.Error())
+			}
+		}
+
+		ctx := r.Context()
+		if haveRoute && picked.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(picked.Timeout))
+			defer cancel()
+		}
 
-		proxyReq, err := http.NewRequest(http.MethodPost, cfg.InferenceBaseURL+"/v1/chat", bytes.NewReader(body))
+		proxyReq, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(body))
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]string{
 				"error": "failed to build request",
@@ -193,14 +283,31 @@ func chatHandler(cfg config, rl *rateLimiter, client *http.Client) http.HandlerF
 			return
 		}
 		proxyReq.Header.Set("Content-Type", "application/json")
-		if auth := r.Header.Get("Authorization"); auth != "" {
+		if haveRoute && picked.AuthHeader != "" {
+			proxyReq.Header.Set("Authorization", picked.AuthHeader)
+		} else if auth := r.Header.Get("Authorization"); auth != "" {
 			proxyReq.Header.Set("Authorization", auth)
 		}
-		if requestID != "" {
-			proxyReq.Header.Set("X-Request-Id", requestID)
+		proxyReq.Header.Set("X-Request-Id", requestID)
+		setUserHeaders(proxyReq, claims)
+
+		if wantsStream(r, body) {
+			if upstream.Open() {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+					"error": "inference service unavailable",
+				})
+				return
+			}
+			proxyReq.Header.Set("Accept", "text/event-stream")
+			if err := streamProxy(w, r, proxyReq, streamClient); err != nil {
+				slog.WarnContext(r.Context(), "stream proxy ended with error",
+					"error", err.Error(), "requestId", requestID)
+			}
+			return
 		}
 
-		resp, err := client.Do(proxyReq)
+		disableHedge := r.Header.Get(disableHedgeHeader) != ""
+		resp, err := upstream.Do(proxyReq, body, disableHedge)
 		if err != nil {
 			writeJSON(w, http.StatusBadGateway, map[string]string{
 				"error": "inference service unreachable",
@@ -209,43 +316,38 @@ func chatHandler(cfg config, rl *rateLimiter, client *http.Client) http.HandlerF
 		}
 		defer resp.Body.Close()
 
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{
+				"error": "failed to read inference response",
+			})
+			return
+		}
+		annotateSpan(r.Context(), claims, "", responseTokens(respBody))
+
 		for key, values := range resp.Header {
 			for _, value := range values {
 				w.Header().Add(key, value)
 			}
 		}
-		if requestID != "" {
-			w.Header().Set("X-Request-Id", requestID)
-		}
+		w.Header().Set("X-Request-Id", requestID)
 		w.WriteHeader(resp.StatusCode)
-		_, _ = io.Copy(w, resp.Body)
+		_, _ = w.Write(respBody)
 	}
 }
 
-func validateJWT(r *http.Request, secret string) error {
+// bearerToken extracts the raw token string from a "Bearer <token>"
+// Authorization header.
+func bearerToken(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return fmt.Errorf("missing authorization header")
+		return "", fmt.Errorf("missing authorization header")
 	}
 	parts := strings.Fields(authHeader)
 	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		return fmt.Errorf("invalid authorization header")
-	}
-
-	tokenString := parts[1]
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-		if token.Method != jwt.SigningMethodHS256 {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return []byte(secret), nil
-	})
-	if err != nil {
-		return err
-	}
-	if !token.Valid {
-		return fmt.Errorf("invalid token")
+		return "", fmt.Errorf("invalid authorization header")
 	}
-	return nil
+	return parts[1], nil
 }
 
 func withLogging(next http.Handler) http.Handler {
@@ -259,14 +361,14 @@ func withLogging(next http.Handler) http.Handler {
 		requestLatency.WithLabelValues(r.URL.Path).Observe(duration.Seconds())
 		requestCount.WithLabelValues(r.Method, r.URL.Path, strconv.Itoa(recorder.status)).Inc()
 
-		logJSON("info", "request", map[string]any{
-			"method":     r.Method,
-			"path":       r.URL.Path,
-			"status":     recorder.status,
-			"durationMs": duration.Milliseconds(),
-			"requestId":  recorder.Header().Get("X-Request-Id"),
-			"ip":         clientIP(r),
-		})
+		slog.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"durationMs", duration.Milliseconds(),
+			"requestId", recorder.Header().Get("X-Request-Id"),
+			"ip", clientIP(r),
+		)
 	})
 }
 
@@ -314,24 +416,6 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
-func logJSON(level string, message string, fields map[string]any) {
-	entry := map[string]any{
-		"level": level,
-		"msg":   message,
-		"time":  time.Now().UTC().Format(time.RFC3339),
-	}
-	for key, value := range fields {
-		entry[key] = value
-	}
-
-	data, err := json.Marshal(entry)
-	if err != nil {
-		log.Printf("{\"level\":\"error\",\"msg\":\"log marshal failed\",\"time\":\"%s\"}", time.Now().UTC().Format(time.RFC3339))
-		return
-	}
-	log.Print(string(data))
-}
-
 func envOrDefault(key, fallback string) string {
 	value := strings.TrimSpace(os.Getenv(key))
 	if value == "" {
@@ -5,13 +5,16 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 func TestHealth(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
 	rec := httptest.NewRecorder()
 
-	healthHandler(rec, req)
+	healthHandler(nil)(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected status 200, got %d", rec.Code)
@@ -23,13 +26,161 @@ func TestHealth(t *testing.T) {
 	}
 }
 
-func TestValidateJWTMissing(t *testing.T) {
+func TestBearerTokenMissing(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/v1/chat", nil)
-	if err := validateJWT(req, "secret"); err == nil {
+	if _, err := bearerToken(req); err == nil {
 		t.Fatalf("expected error for missing token")
 	}
 }
 
+// TestChatHandlerIntegration exercises chatHandler end to end (auth, rate
+// limiting, proxying, response passthrough) against a fake upstream, since
+// its constituent pieces are otherwise only ever unit-tested in isolation.
+func TestChatHandlerIntegration(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"usage":{"total_tokens":7}}`))
+	}))
+	defer upstream.Close()
+
+	cfg := config{InferenceBaseURL: upstream.URL, ChatRequireScope: "chat:write"}
+	limiter, err := newMemoryLimiter(rateSpec{rps: 10, burst: 10}, 100)
+	if err != nil {
+		t.Fatalf("new limiter: %v", err)
+	}
+	upstreamClient := newUpstreamClient(upstream.Client(), retryPolicy{
+		maxAttempts: 1, baseDelay: time.Millisecond, maxDelay: time.Millisecond,
+	}, 0)
+	verifier := newHS256Verifier("test-secret")
+
+	handler := chatHandler(cfg, limiter, upstreamClient, upstream.Client(), verifier, nil)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user-1", "scope": "chat:write", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{"model":"m"}`))
+	req.Header.Set("Authorization", "Bearer "+signed)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Request-Id") == "" {
+		t.Fatalf("expected an X-Request-Id to be set on the response")
+	}
+	if !strings.Contains(rec.Body.String(), "total_tokens") {
+		t.Fatalf("expected the upstream response to be proxied through, got %s", rec.Body.String())
+	}
+}
+
+func TestChatHandlerIntegrationRejectsMissingToken(t *testing.T) {
+	cfg := config{InferenceBaseURL: "http://unused", ChatRequireScope: "chat:write"}
+	limiter, err := newMemoryLimiter(rateSpec{rps: 10, burst: 10}, 100)
+	if err != nil {
+		t.Fatalf("new limiter: %v", err)
+	}
+	upstreamClient := newUpstreamClient(http.DefaultClient, retryPolicy{
+		maxAttempts: 1, baseDelay: time.Millisecond, maxDelay: time.Millisecond,
+	}, 0)
+	verifier := newHS256Verifier("test-secret")
+
+	handler := chatHandler(cfg, limiter, upstreamClient, http.DefaultClient, verifier, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rec.Code)
+	}
+}
+
+func TestChatHandlerIntegrationEnforcesRateLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := config{InferenceBaseURL: upstream.URL}
+	limiter, err := newMemoryLimiter(rateSpec{rps: 1, burst: 1}, 100)
+	if err != nil {
+		t.Fatalf("new limiter: %v", err)
+	}
+	upstreamClient := newUpstreamClient(upstream.Client(), retryPolicy{
+		maxAttempts: 1, baseDelay: time.Millisecond, maxDelay: time.Millisecond,
+	}, 0)
+
+	handler := chatHandler(cfg, limiter, upstreamClient, upstream.Client(), nil, nil)
+
+	req1 := httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{}`))
+	rec1 := httptest.NewRecorder()
+	handler(rec1, req1)
+	if rec1.Code != http.StatusOK {
+		t.Fatalf("expected the first request to be allowed, got %d", rec1.Code)
+	}
+
+	req2 := httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{}`))
+	rec2 := httptest.NewRecorder()
+	handler(rec2, req2)
+	if rec2.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the second request within the same burst to be throttled, got %d", rec2.Code)
+	}
+}
+
+// TestChatHandlerStreamFailsFastWhenBreakerOpen verifies that a streaming
+// request, which bypasses upstreamClient.Do (and so gets no retry/hedge
+// protection of its own), still respects an already-open breaker instead of
+// dispatching straight to a known-bad upstream.
+func TestChatHandlerStreamFailsFastWhenBreakerOpen(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	cfg := config{InferenceBaseURL: upstream.URL}
+	limiter, err := newMemoryLimiter(rateSpec{rps: 100, burst: 100}, 100)
+	if err != nil {
+		t.Fatalf("new limiter: %v", err)
+	}
+	upstreamClient := newUpstreamClient(upstream.Client(), retryPolicy{
+		maxAttempts: 1, baseDelay: time.Millisecond, maxDelay: time.Millisecond,
+	}, 0)
+
+	handler := chatHandler(cfg, limiter, upstreamClient, upstream.Client(), nil, nil)
+
+	// Trip the breaker via 5 non-streaming requests before trying to stream.
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{}`))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+	}
+	if !upstreamClient.Open() {
+		t.Fatalf("expected the breaker to be open after 5 consecutive 5xx responses")
+	}
+
+	before := requests
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{"stream":true}`))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when streaming with the breaker open, got %d", rec.Code)
+	}
+	if requests != before {
+		t.Fatalf("expected the stream request to fail fast without reaching the upstream, got %d new requests", requests-before)
+	}
+}
+
 func containsAll(body string, terms []string) bool {
 	for _, term := range terms {
 		if !strings.Contains(body, term) {
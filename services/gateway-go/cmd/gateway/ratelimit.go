@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+var throttledTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gateway_rate_limit_throttled_total",
+		Help: "Requests rejected by the rate limiter",
+	},
+	[]string{"route"},
+)
+
+func init() {
+	prometheus.MustRegister(throttledTotal)
+}
+
+// LimitResult describes the outcome of a rate limit check, carrying enough
+// detail to populate RFC-style rate limit response headers.
+type LimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether a request identified by key is allowed to proceed.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (LimitResult, error)
+}
+
+// rateSpec is a parsed "N/s" rate limit expression plus its burst size.
+type rateSpec struct {
+	rps   float64
+	burst int
+}
+
+// parseRateSpec parses strings like "10/s" or "1/s" into a rateSpec. Only
+// per-second rates are supported, matching RATE_LIMIT_* env vars.
+func parseRateSpec(s string, burst int) (rateSpec, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[1] != "s" {
+		return rateSpec{}, fmt.Errorf("invalid rate spec %q, expected format N/s", s)
+	}
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return rateSpec{}, fmt.Errorf("invalid rate spec %q: %w", s, err)
+	}
+	return rateSpec{rps: n, burst: burst}, nil
+}
+
+// memoryLimiter is an in-process token bucket per key, bounded by an LRU
+// cache so long-lived gateways with many distinct subjects/IPs don't grow
+// unbounded the way the old per-second counter map did.
+type memoryLimiter struct {
+	spec    rateSpec
+	buckets *lru.Cache[string, *rate.Limiter]
+}
+
+func newMemoryLimiter(spec rateSpec, maxKeys int) (*memoryLimiter, error) {
+	cache, err := lru.New[string, *rate.Limiter](maxKeys)
+	if err != nil {
+		return nil, fmt.Errorf("create rate limit cache: %w", err)
+	}
+	return &memoryLimiter{spec: spec, buckets: cache}, nil
+}
+
+func (m *memoryLimiter) Allow(_ context.Context, key string) (LimitResult, error) {
+	limiter, ok := m.buckets.Get(key)
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(m.spec.rps), m.spec.burst)
+		m.buckets.Add(key, limiter)
+	}
+
+	reservation := limiter.Reserve()
+	if !reservation.OK() {
+		return LimitResult{Allowed: false, Limit: m.spec.burst}, nil
+	}
+	delay := reservation.Delay()
+	if delay > 0 {
+		reservation.Cancel()
+		return LimitResult{
+			Allowed:    false,
+			Limit:      m.spec.burst,
+			RetryAfter: delay,
+		}, nil
+	}
+	return LimitResult{
+		Allowed:   true,
+		Limit:     m.spec.burst,
+		Remaining: int(limiter.Tokens()),
+	}, nil
+}
+
+// redisLimiter implements a sliding-window limiter shared across gateway
+// replicas. Each check is a single round-trip running a Lua script that
+// trims entries outside the window, counts what's left, and records the
+// current request only if that count is under the limit (rejected requests
+// are not recorded) - so concurrent pods never race on read-then-write.
+type redisLimiter struct {
+	client *redis.Client
+	spec   rateSpec
+	window time.Duration
+	script *redis.Script
+}
+
+const slidingWindowScript = `
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window_start = now - tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", window_start)
+local count = redis.call("ZCARD", key)
+if count >= limit then
+	return {0, count}
+end
+
+redis.call("ZADD", key, now, member)
+redis.call("PEXPIRE", key, math.ceil(tonumber(ARGV[2])))
+return {1, count + 1}
+`
+
+// newRedisLimiter sizes the sliding window so that spec.burst requests over
+// it average out to spec.rps (window = burst/rps), the same throttling a
+// token bucket with these parameters would apply in steady state - rather
+// than hardcoding a 1s window and ignoring rps entirely.
+func newRedisLimiter(client *redis.Client, spec rateSpec) *redisLimiter {
+	window := time.Second
+	if spec.rps > 0 {
+		window = time.Duration(float64(spec.burst) / spec.rps * float64(time.Second))
+	}
+	return &redisLimiter{
+		client: client,
+		spec:   spec,
+		window: window,
+		script: redis.NewScript(slidingWindowScript),
+	}
+}
+
+func (r *redisLimiter) Allow(ctx context.Context, key string) (LimitResult, error) {
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%s", now, newUUID())
+	result, err := r.script.Run(ctx, r.client, []string{"ratelimit:" + key},
+		now, r.window.Milliseconds(), r.spec.burst, member).Result()
+	if err != nil {
+		return LimitResult{}, fmt.Errorf("redis rate limit: %w", err)
+	}
+
+	values, ok := result.([]any)
+	if !ok || len(values) != 2 {
+		return LimitResult{}, fmt.Errorf("redis rate limit: unexpected script result %v", result)
+	}
+	allowed, _ := values[0].(int64)
+	count, _ := values[1].(int64)
+
+	remaining := r.spec.burst - int(count)
+	if remaining < 0 {
+		remaining = 0
+	}
+	res := LimitResult{
+		Allowed:   allowed == 1,
+		Limit:     r.spec.burst,
+		Remaining: remaining,
+	}
+	if !res.Allowed {
+		res.RetryAfter = r.window
+	}
+	return res, nil
+}
+
+// rateLimitKey selects the subject to rate limit by: the authenticated
+// `sub` claim when present, falling back to the client IP for anonymous
+// requests.
+func rateLimitKey(r *http.Request) string {
+	if claims, ok := claimsFromContext(r.Context()); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject
+	}
+	return "ip:" + clientIP(r)
+}
+
+func applyRateLimitHeaders(w http.ResponseWriter, result LimitResult) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	if !result.Allowed && result.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(result.RetryAfter.Seconds())))
+	}
+}
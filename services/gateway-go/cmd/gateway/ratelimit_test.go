@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseRateSpec(t *testing.T) {
+	spec, err := parseRateSpec("10/s", 20)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if spec.rps != 10 || spec.burst != 20 {
+		t.Fatalf("unexpected spec: %+v", spec)
+	}
+
+	if _, err := parseRateSpec("10/m", 20); err == nil {
+		t.Fatalf("expected error for unsupported unit")
+	}
+	if _, err := parseRateSpec("bogus", 20); err == nil {
+		t.Fatalf("expected error for malformed spec")
+	}
+}
+
+func TestMemoryLimiterAllowsWithinBurst(t *testing.T) {
+	spec, err := parseRateSpec("1/s", 2)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	limiter, err := newMemoryLimiter(spec, 10)
+	if err != nil {
+		t.Fatalf("new limiter: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "sub:alice")
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("expected request %d within burst to be allowed", i)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "sub:alice")
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if result.Allowed {
+		t.Fatalf("expected request beyond burst to be throttled")
+	}
+	if result.RetryAfter <= 0 {
+		t.Fatalf("expected a positive retry-after when throttled")
+	}
+}
+
+func TestMemoryLimiterIsolatesKeys(t *testing.T) {
+	spec, err := parseRateSpec("1/s", 1)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	limiter, err := newMemoryLimiter(spec, 10)
+	if err != nil {
+		t.Fatalf("new limiter: %v", err)
+	}
+
+	ctx := context.Background()
+	if result, err := limiter.Allow(ctx, "sub:alice"); err != nil || !result.Allowed {
+		t.Fatalf("expected alice's first request to be allowed: result=%+v err=%v", result, err)
+	}
+	if result, err := limiter.Allow(ctx, "sub:bob"); err != nil || !result.Allowed {
+		t.Fatalf("expected bob's first request to be allowed independently: result=%+v err=%v", result, err)
+	}
+}
+
+func TestRedisLimiterSlidingWindow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	spec, err := parseRateSpec("1/s", 2)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	limiter := newRedisLimiter(client, spec)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "sub:alice")
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("expected request %d within burst to be allowed", i)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "sub:alice")
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if result.Allowed {
+		t.Fatalf("expected third request to be throttled")
+	}
+
+	mr.FastForward(2 * limiter.window)
+	result, err = limiter.Allow(ctx, "sub:alice")
+	if err != nil {
+		t.Fatalf("allow after window: %v", err)
+	}
+	if !result.Allowed {
+		t.Fatalf("expected request to be allowed after the window slid")
+	}
+}
+
+func TestNewRedisLimiterSizesWindowFromRPS(t *testing.T) {
+	slow, err := parseRateSpec("1/s", 20)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	fast, err := parseRateSpec("9999/s", 20)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	slowLimiter := newRedisLimiter(nil, slow)
+	fastLimiter := newRedisLimiter(nil, fast)
+
+	if slowLimiter.window <= fastLimiter.window {
+		t.Fatalf("expected a lower rps to produce a longer window: slow=%s fast=%s",
+			slowLimiter.window, fastLimiter.window)
+	}
+	if fastLimiter.window >= 100*time.Millisecond {
+		t.Fatalf("expected a high rps to produce a short window, got %s", fastLimiter.window)
+	}
+}
+
+func TestRedisLimiterThrottlesSteadyStateByRPS(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	// burst=2, rps=1 -> a 2s window allowing 2 requests, i.e. ~1 req/s
+	// sustained, same as the in-memory token bucket would allow.
+	spec, err := parseRateSpec("1/s", 2)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	limiter := newRedisLimiter(client, spec)
+	if limiter.window != 2*time.Second {
+		t.Fatalf("expected a 2s window for burst=2/rps=1, got %s", limiter.window)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "sub:alice")
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("expected request %d within burst to be allowed", i)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "sub:alice")
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if result.Allowed {
+		t.Fatalf("expected the third request within the rps-sized window to be throttled")
+	}
+
+	mr.FastForward(1 * time.Second) // half the window: still rate-limited
+	result, err = limiter.Allow(ctx, "sub:alice")
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if result.Allowed {
+		t.Fatalf("expected the request to still be throttled before the full rps-sized window elapsed")
+	}
+}
+
+func TestRateLimitKeyPrefersSubject(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat", nil)
+	req = req.WithContext(withClaims(req.Context(), &Claims{Subject: "alice"}))
+	if got := rateLimitKey(req); got != "sub:alice" {
+		t.Fatalf("expected sub:alice, got %q", got)
+	}
+}
+
+func TestRateLimitKeyFallsBackToIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat", nil)
+	if got := rateLimitKey(req); got == "" {
+		t.Fatalf("expected a non-empty IP-based key")
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker/v2"
+)
+
+const disableHedgeHeader = "X-Disable-Hedge"
+
+var (
+	breakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_circuit_breaker_state",
+		Help: "Upstream circuit breaker state (0=closed, 1=half-open, 2=open)",
+	})
+	retryTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_upstream_retries_total",
+		Help: "Total retry attempts made against the inference upstream",
+	})
+	hedgeTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_upstream_hedged_total",
+		Help: "Total hedged requests dispatched against the inference upstream",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(breakerState, retryTotal, hedgeTotal)
+}
+
+// retryPolicy controls how many times, and with what backoff, a failed
+// upstream attempt is retried.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// backoff returns the delay before the given retry attempt (0-indexed,
+// counting the first retry as attempt 1), using exponential backoff with
+// full jitter so retrying gateway pods don't all hammer the upstream in
+// lockstep.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.baseDelay << attempt
+	if d <= 0 || d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// isRetryable decides whether an upstream attempt should be retried. Both
+// connection-level errors and 5xx responses are only retried for idempotent
+// methods: a connection error can occur after the upstream already started
+// or finished processing the request, so resending a non-idempotent POST
+// (e.g. a chat completion, with no idempotency key) risks a duplicate
+// inference call.
+func isRetryable(method string, resp *http.Response, err error) bool {
+	if !isIdempotentMethod(method) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp != nil && resp.StatusCode >= 500
+}
+
+// upstreamStatusError marks a 5xx upstream response as a breaker-visible
+// failure without discarding the response itself: gobreaker's Execute only
+// counts a closure as failed when it returns a non-nil error, so without
+// this the breaker would never see an up-but-erroring backend, only
+// transport-level failures.
+type upstreamStatusError struct {
+	statusCode int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream returned status %d", e.statusCode)
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// upstreamClient wraps the plain *http.Client used for non-streaming
+// inference calls with a circuit breaker, retries, and optional hedged
+// requests.
+type upstreamClient struct {
+	client     *http.Client
+	breaker    *gobreaker.CircuitBreaker[*http.Response]
+	retry      retryPolicy
+	hedgeDelay time.Duration
+}
+
+func newUpstreamClient(client *http.Client, retry retryPolicy, hedgeDelay time.Duration) *upstreamClient {
+	settings := gobreaker.Settings{
+		Name:        "inference-upstream",
+		MaxRequests: 3,
+		Interval:    time.Minute,
+		Timeout:     30 * time.Second,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 5
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			breakerState.Set(float64(to))
+		},
+	}
+	return &upstreamClient{
+		client:     client,
+		breaker:    gobreaker.NewCircuitBreaker[*http.Response](settings),
+		retry:      retry,
+		hedgeDelay: hedgeDelay,
+	}
+}
+
+// Open reports whether the circuit breaker is currently tripped. Streaming
+// requests bypass Do entirely (their response can't be buffered and
+// retried), so this lets callers fail fast instead of dispatching a request
+// that the breaker would have rejected anyway.
+func (u *upstreamClient) Open() bool {
+	return u.breaker.State() == gobreaker.StateOpen
+}
+
+// Do executes req (whose body must be fully buffered, so it can be resent on
+// retries and hedges) through the circuit breaker, retrying on transient
+// failures and optionally racing a hedge request if the primary is slow.
+func (u *upstreamClient) Do(req *http.Request, body []byte, disableHedge bool) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt < u.retry.maxAttempts; attempt++ {
+		if attempt > 0 {
+			retryTotal.Inc()
+			time.Sleep(u.retry.backoff(attempt))
+		}
+
+		resp, err := u.breaker.Execute(func() (*http.Response, error) {
+			var r *http.Response
+			var doErr error
+			if disableHedge || u.hedgeDelay <= 0 {
+				r, doErr = u.client.Do(cloneRequest(req, body))
+			} else {
+				r, doErr = u.hedgedDo(req, body)
+			}
+			if doErr == nil && r != nil && r.StatusCode >= 500 {
+				// Surface the response as a failure to the breaker while
+				// still handing the caller the real *http.Response below.
+				return r, &upstreamStatusError{statusCode: r.StatusCode}
+			}
+			return r, doErr
+		})
+		if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+			return nil, err
+		}
+
+		var statusErr *upstreamStatusError
+		if errors.As(err, &statusErr) {
+			err = nil // the response is valid; only the breaker needed to see a failure
+		}
+
+		if !isRetryable(req.Method, resp, err) {
+			return resp, err
+		}
+
+		lastErr = err
+		if resp != nil {
+			lastErr = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+			resp.Body.Close()
+		}
+	}
+	return nil, lastErr
+}
+
+// hedgedDo dispatches req and, if it hasn't produced a response within
+// hedgeDelay, fires a second identical request in parallel. Each attempt gets
+// its own cancelable context derived from req's context, so declaring a
+// winner only cancels the loser - the winner's response body is still being
+// read by the caller and must stay alive.
+func (u *upstreamClient) hedgedDo(req *http.Request, body []byte) (*http.Response, error) {
+	type result struct {
+		resp   *http.Response
+		err    error
+		cancel context.CancelFunc
+	}
+
+	launch := func() result {
+		ctx, cancel := context.WithCancel(req.Context())
+		resp, err := u.client.Do(cloneRequest(req.WithContext(ctx), body))
+		return result{resp: resp, err: err, cancel: cancel}
+	}
+
+	results := make(chan result, 2)
+	go func() { results <- launch() }()
+
+	timer := time.NewTimer(u.hedgeDelay)
+	defer timer.Stop()
+
+	select {
+	case res := <-results:
+		return res.resp, res.err
+	case <-timer.C:
+		hedgeTotal.Inc()
+		go func() { results <- launch() }()
+	}
+
+	arrival := <-results
+	if arrival.err == nil {
+		go func() {
+			loser := <-results
+			loser.cancel()
+			if loser.resp != nil {
+				loser.resp.Body.Close()
+			}
+		}()
+		return arrival.resp, nil
+	}
+	second := <-results
+	return second.resp, second.err
+}
+
+func cloneRequest(req *http.Request, body []byte) *http.Request {
+	clone := req.Clone(req.Context())
+	if body != nil {
+		clone.Body = io.NopCloser(bytes.NewReader(body))
+		clone.ContentLength = int64(len(body))
+	}
+	return clone
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+)
+
+func TestIsRetryable(t *testing.T) {
+	if isRetryable(http.MethodPost, nil, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected a non-idempotent method to not retry on connection errors")
+	}
+	if !isRetryable(http.MethodGet, nil, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected an idempotent method to retry on connection errors")
+	}
+	if isRetryable(http.MethodPost, &http.Response{StatusCode: 502}, nil) {
+		t.Fatalf("expected a non-idempotent method to not retry on 5xx")
+	}
+	if !isRetryable(http.MethodGet, &http.Response{StatusCode: 503}, nil) {
+		t.Fatalf("expected an idempotent method to retry on 5xx")
+	}
+	if isRetryable(http.MethodGet, &http.Response{StatusCode: 404}, nil) {
+		t.Fatalf("expected 4xx to never be retried")
+	}
+}
+
+func TestUpstreamClientRetriesTransientFailures(t *testing.T) {
+	var attempts int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	client := newUpstreamClient(upstream.Client(), retryPolicy{
+		maxAttempts: 5,
+		baseDelay:   time.Millisecond,
+		maxDelay:    10 * time.Millisecond,
+	}, 0)
+
+	req := httptest.NewRequest(http.MethodGet, upstream.URL, nil)
+	req.RequestURI = ""
+	resp, err := client.Do(req, nil, true)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected eventual 200, got %d", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestUpstreamClientDoesNotRetryNonIdempotentOn5xx(t *testing.T) {
+	var attempts int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	client := newUpstreamClient(upstream.Client(), retryPolicy{
+		maxAttempts: 5,
+		baseDelay:   time.Millisecond,
+		maxDelay:    10 * time.Millisecond,
+	}, 0)
+
+	req := httptest.NewRequest(http.MethodPost, upstream.URL, nil)
+	req.RequestURI = ""
+	resp, err := client.Do(req, []byte("{}"), true)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("expected exactly 1 attempt for a non-idempotent POST, got %d", got)
+	}
+}
+
+func TestUpstreamClientBreakerTripsOn5xx(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	// POST is non-idempotent, so each Do call makes exactly one breaker
+	// Execute call regardless of maxAttempts - isolating the breaker's
+	// failure count from the retry loop.
+	client := newUpstreamClient(upstream.Client(), retryPolicy{
+		maxAttempts: 1,
+		baseDelay:   time.Millisecond,
+		maxDelay:    10 * time.Millisecond,
+	}, 0)
+
+	req := httptest.NewRequest(http.MethodPost, upstream.URL, nil)
+	req.RequestURI = ""
+
+	for i := 0; i < 5; i++ {
+		resp, err := client.Do(req, []byte("{}"), true)
+		if err != nil {
+			t.Fatalf("attempt %d: unexpected error %v", i, err)
+		}
+		resp.Body.Close()
+	}
+
+	if !client.Open() {
+		t.Fatalf("expected the breaker to be open after 5 consecutive 5xx responses")
+	}
+	if _, err := client.Do(req, []byte("{}"), true); !errors.Is(err, gobreaker.ErrOpenState) {
+		t.Fatalf("expected ErrOpenState once the breaker trips, got %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 5 {
+		t.Fatalf("expected exactly 5 requests to reach the upstream before the breaker opened, got %d", got)
+	}
+}
+
+func TestUpstreamClientHedgeWinsOverSlowOriginal(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The request that arrives first (the original) is slow; the hedge,
+		// fired after hedgeDelay, should win the race.
+		if atomic.AddInt32(&requests, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	client := newUpstreamClient(upstream.Client(), retryPolicy{
+		maxAttempts: 1,
+		baseDelay:   time.Millisecond,
+		maxDelay:    10 * time.Millisecond,
+	}, 20*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodPost, upstream.URL, nil)
+	req.RequestURI = ""
+
+	start := time.Now()
+	resp, err := client.Do(req, []byte("{}"), false)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if elapsed > 150*time.Millisecond {
+		t.Fatalf("expected the hedge to win well before the slow original finished, took %s", elapsed)
+	}
+
+	time.Sleep(250 * time.Millisecond) // let the slow original drain in the background
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("expected exactly 2 requests (original + hedge), got %d", got)
+	}
+}
+
+func TestUpstreamClientHedgeWinnerBodyStaysReadableAfterLoserCancelled(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// original: slow to respond at all, so the hedge wins the race,
+			// but it answers (and is cancelled) while the hedge is still
+			// streaming its ~100ms body.
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// hedge: responds immediately, then streams its body slowly so it is
+		// still in flight when hedgedDo cancels the loser (the original).
+		w.WriteHeader(http.StatusOK)
+		flusher := w.(http.Flusher)
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("chunk"))
+			flusher.Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+
+	client := newUpstreamClient(upstream.Client(), retryPolicy{
+		maxAttempts: 1,
+		baseDelay:   time.Millisecond,
+		maxDelay:    10 * time.Millisecond,
+	}, 10*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodPost, upstream.URL, nil)
+	req.RequestURI = ""
+
+	resp, err := client.Do(req, []byte("{}"), false)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the hedge winner's body after the loser was cancelled: %v", err)
+	}
+	if want := strings.Repeat("chunk", 5); string(got) != want {
+		t.Fatalf("expected the full streamed body %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Upstream is one weighted backend a model route can be split across.
+type Upstream struct {
+	Name       string         `json:"name" yaml:"name"`
+	URL        string         `json:"url" yaml:"url"`
+	Weight     int            `json:"weight" yaml:"weight"`
+	Timeout    durationString `json:"timeout" yaml:"timeout"`
+	AuthHeader string         `json:"authHeader" yaml:"authHeader"`
+}
+
+// durationString parses a human-readable duration like "2s" or "500ms" from
+// JSON/YAML config, since neither format encodes time.Duration that way on
+// its own - operators shouldn't have to write raw nanosecond integers.
+type durationString time.Duration
+
+func (d *durationString) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = durationString(parsed)
+	return nil
+}
+
+func (d *durationString) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = durationString(parsed)
+	return nil
+}
+
+// ModelRoute maps a model name (matched against the request body's `model`
+// field) to the set of upstreams it can be split across.
+type ModelRoute struct {
+	Model     string     `json:"model" yaml:"model"`
+	Upstreams []Upstream `json:"upstreams" yaml:"upstreams"`
+}
+
+// RoutingTable is the full config-driven routing table loaded from
+// GATEWAY_CONFIG. Version is operator-supplied (e.g. a deploy SHA) and
+// surfaced on /admin/config purely for humans; reload races are guarded by
+// a content fingerprint instead, since operators can forget to bump it.
+type RoutingTable struct {
+	Version string       `json:"version" yaml:"version"`
+	Routes  []ModelRoute `json:"routes" yaml:"routes"`
+	Default *ModelRoute  `json:"default,omitempty" yaml:"default,omitempty"`
+	fp      [sha256.Size]byte
+}
+
+func (t *RoutingTable) routeFor(model string) (ModelRoute, bool) {
+	for _, route := range t.Routes {
+		if route.Model == model {
+			return route, true
+		}
+	}
+	if t.Default != nil {
+		return *t.Default, true
+	}
+	return ModelRoute{}, false
+}
+
+// ConfigHandler is the reloadable-router contract: inspect the active table
+// and trigger a reload (e.g. on SIGHUP) without racing requests in flight.
+type ConfigHandler interface {
+	Current() *RoutingTable
+	Reload() error
+}
+
+// Router selects an upstream for a chat request based on the configured
+// routing table, with weighted A/B splits per model.
+type Router struct {
+	path string
+
+	mu    sync.RWMutex
+	table *RoutingTable
+
+	randMu sync.Mutex
+	rng    *rand.Rand
+}
+
+// NewRouter loads the routing table from path (YAML or JSON, by extension)
+// and returns a Router ready to serve Select/Reload calls.
+func NewRouter(path string) (*Router, error) {
+	r := &Router{
+		path: path,
+		rng:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Current returns the active routing table. Safe for concurrent use.
+func (r *Router) Current() *RoutingTable {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.table
+}
+
+// Reload re-reads the config file and swaps the active table under a write
+// lock, but only if its content fingerprint changed - so a reload triggered
+// while nothing moved is a no-op rather than a race-prone no-op swap.
+func (r *Router) Reload() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("read gateway config: %w", err)
+	}
+	fp := sha256.Sum256(data)
+
+	r.mu.RLock()
+	unchanged := r.table != nil && r.table.fp == fp
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	table, err := parseRoutingTable(r.path, data)
+	if err != nil {
+		return fmt.Errorf("parse gateway config: %w", err)
+	}
+	table.fp = fp
+
+	r.mu.Lock()
+	r.table = table
+	r.mu.Unlock()
+	return nil
+}
+
+func parseRoutingTable(path string, data []byte) (*RoutingTable, error) {
+	table := &RoutingTable{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, table); err != nil {
+			return nil, err
+		}
+	case ".json", "":
+		if err := json.Unmarshal(data, table); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported gateway config extension %q", ext)
+	}
+	return table, nil
+}
+
+// Select picks an upstream for model via a weighted random split. Selection
+// uses the router's own RNG so tests can seed it for deterministic results.
+func (r *Router) Select(model string) (Upstream, error) {
+	table := r.Current()
+	if table == nil {
+		return Upstream{}, fmt.Errorf("no routing table loaded")
+	}
+	route, ok := table.routeFor(model)
+	if !ok || len(route.Upstreams) == 0 {
+		return Upstream{}, fmt.Errorf("no route configured for model %q", model)
+	}
+
+	r.randMu.Lock()
+	roll := r.rng.Float64()
+	r.randMu.Unlock()
+	return selectWeighted(route.Upstreams, roll), nil
+}
+
+// selectWeighted picks from upstreams proportionally to weight, given roll
+// in [0, 1). It's a pure function (no RNG access) so weighted-split
+// determinism can be tested directly against fixed rolls.
+func selectWeighted(upstreams []Upstream, roll float64) Upstream {
+	total := 0
+	for _, u := range upstreams {
+		if u.Weight <= 0 {
+			continue
+		}
+		total += u.Weight
+	}
+	if total == 0 {
+		return upstreams[0]
+	}
+
+	target := roll * float64(total)
+	var cumulative float64
+	for _, u := range upstreams {
+		if u.Weight <= 0 {
+			continue
+		}
+		cumulative += float64(u.Weight)
+		if target < cumulative {
+			return u
+		}
+	}
+	return upstreams[len(upstreams)-1]
+}
+
+// watchReload reloads the routing table whenever sig fires (wired to
+// SIGHUP by the caller), logging the outcome.
+func (r *Router) watchReload(sig <-chan os.Signal) {
+	for range sig {
+		if err := r.Reload(); err != nil {
+			slog.Error("gateway config reload failed", "error", err.Error())
+			continue
+		}
+		slog.Info("gateway config reloaded")
+	}
+}
+
+// adminConfigHandler exposes the active routing table for inspection, gated
+// behind the same bearer-token verifier used for /v1/chat plus requireScope
+// (so a regular chat token can't read it). Upstream credentials are stripped
+// from the response regardless, since they're meant for the gateway's eyes
+// only.
+func adminConfigHandler(router *Router, verifier TokenVerifier, requireScope string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if verifier != nil {
+			tokenString, err := bearerToken(r)
+			if err != nil {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+			claims, err := verifier.Verify(tokenString)
+			if err != nil {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+			if requireScope != "" && !claims.HasScope(requireScope) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "insufficient scope"})
+				return
+			}
+		}
+		if router == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no routing table configured"})
+			return
+		}
+		writeJSON(w, http.StatusOK, router.Current().redacted())
+	}
+}
+
+// redacted returns a copy of t with upstream credentials stripped, safe to
+// serialize to an authenticated-but-not-necessarily-trusted caller.
+func (t *RoutingTable) redacted() *RoutingTable {
+	if t == nil {
+		return nil
+	}
+	redactRoute := func(route ModelRoute) ModelRoute {
+		upstreams := make([]Upstream, len(route.Upstreams))
+		for i, u := range route.Upstreams {
+			u.AuthHeader = ""
+			upstreams[i] = u
+		}
+		route.Upstreams = upstreams
+		return route
+	}
+
+	routes := make([]ModelRoute, len(t.Routes))
+	for i, route := range t.Routes {
+		routes[i] = redactRoute(route)
+	}
+	var def *ModelRoute
+	if t.Default != nil {
+		d := redactRoute(*t.Default)
+		def = &d
+	}
+	return &RoutingTable{Version: t.Version, Routes: routes, Default: def}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSelectWeightedDeterministic(t *testing.T) {
+	upstreams := []Upstream{
+		{Name: "primary", Weight: 80},
+		{Name: "canary", Weight: 20},
+	}
+
+	if got := selectWeighted(upstreams, 0); got.Name != "primary" {
+		t.Fatalf("expected primary at roll 0, got %q", got.Name)
+	}
+	if got := selectWeighted(upstreams, 0.79); got.Name != "primary" {
+		t.Fatalf("expected primary at roll 0.79, got %q", got.Name)
+	}
+	if got := selectWeighted(upstreams, 0.81); got.Name != "canary" {
+		t.Fatalf("expected canary at roll 0.81, got %q", got.Name)
+	}
+}
+
+func TestSelectWeightedIgnoresNonPositiveWeights(t *testing.T) {
+	upstreams := []Upstream{
+		{Name: "zero", Weight: 0},
+		{Name: "only", Weight: 1},
+	}
+	if got := selectWeighted(upstreams, 0.5); got.Name != "only" {
+		t.Fatalf("expected the only positively-weighted upstream, got %q", got.Name)
+	}
+}
+
+func TestRouterSelectUsesSeededRNG(t *testing.T) {
+	path := writeRoutingTable(t, `{
+		"routes": [
+			{"model": "gpt-oss-20b", "upstreams": [
+				{"name": "primary", "url": "http://primary", "weight": 1},
+				{"name": "canary", "url": "http://canary", "weight": 1}
+			]}
+		]
+	}`)
+
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+	router.rng = rand.New(rand.NewSource(1))
+	replay := rand.New(rand.NewSource(1))
+	upstreams := router.Current().Routes[0].Upstreams
+
+	for i := 0; i < 10; i++ {
+		upstream, err := router.Select("gpt-oss-20b")
+		if err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if want := selectWeighted(upstreams, replay.Float64()); upstream.Name != want.Name {
+			t.Fatalf("selection %d: expected %q from the seeded RNG, got %q", i, want.Name, upstream.Name)
+		}
+	}
+}
+
+func TestRouterSelectFallsBackToDefault(t *testing.T) {
+	path := writeRoutingTable(t, `{
+		"routes": [],
+		"default": {"model": "*", "upstreams": [{"name": "catch-all", "url": "http://catch-all", "weight": 1}]}
+	}`)
+
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+
+	upstream, err := router.Select("unrouted-model")
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if upstream.Name != "catch-all" {
+		t.Fatalf("expected catch-all, got %q", upstream.Name)
+	}
+}
+
+func TestRouterSelectErrorsWithoutMatch(t *testing.T) {
+	path := writeRoutingTable(t, `{"routes": []}`)
+
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+	if _, err := router.Select("unrouted-model"); err == nil {
+		t.Fatalf("expected an error for an unrouted model with no default")
+	}
+}
+
+func TestRouterReloadIsNoOpWhenContentUnchanged(t *testing.T) {
+	path := writeRoutingTable(t, `{
+		"routes": [{"model": "m", "upstreams": [{"name": "a", "url": "http://a", "weight": 1}]}]
+	}`)
+
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+	before := router.Current()
+
+	if err := router.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if router.Current() != before {
+		t.Fatalf("expected unchanged content to leave the active table untouched")
+	}
+}
+
+func TestRouterReloadSwapsOnChange(t *testing.T) {
+	path := writeRoutingTable(t, `{
+		"routes": [{"model": "m", "upstreams": [{"name": "a", "url": "http://a", "weight": 1}]}]
+	}`)
+
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{
+		"routes": [{"model": "m", "upstreams": [{"name": "b", "url": "http://b", "weight": 1}]}]
+	}`), 0o644); err != nil {
+		t.Fatalf("rewrite config: %v", err)
+	}
+	if err := router.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	upstream, err := router.Select("m")
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if upstream.Name != "b" {
+		t.Fatalf("expected reload to pick up the new upstream, got %q", upstream.Name)
+	}
+}
+
+func TestAdminConfigHandlerRequiresAuth(t *testing.T) {
+	path := writeRoutingTable(t, `{"routes": []}`)
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+	verifier := &hs256Verifier{secret: []byte("test-secret")}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+	rec := httptest.NewRecorder()
+	adminConfigHandler(router, verifier, "admin:read")(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rec.Code)
+	}
+}
+
+func TestAdminConfigHandlerRequiresAdminScope(t *testing.T) {
+	path := writeRoutingTable(t, `{"routes": []}`)
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+	verifier := &hs256Verifier{secret: []byte("test-secret")}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   "chat-user",
+		"scope": "chat:write",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	rec := httptest.NewRecorder()
+	adminConfigHandler(router, verifier, "admin:read")(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a token without admin:read, got %d", rec.Code)
+	}
+}
+
+func TestAdminConfigHandlerRedactsAuthHeaders(t *testing.T) {
+	path := writeRoutingTable(t, `{
+		"routes": [{"model": "m", "upstreams": [
+			{"name": "a", "url": "http://a", "weight": 1, "authHeader": "Bearer super-secret"}
+		]}]
+	}`)
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+	rec := httptest.NewRecorder()
+	adminConfigHandler(router, nil, "")(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "super-secret") {
+		t.Fatalf("expected upstream auth headers to be redacted, got %s", rec.Body.String())
+	}
+}
+
+func TestAdminConfigHandlerNotFoundWithoutRouter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+	rec := httptest.NewRecorder()
+	adminConfigHandler(nil, nil, "")(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a configured router, got %d", rec.Code)
+	}
+}
+
+func TestUpstreamTimeoutParsesHumanDuration(t *testing.T) {
+	path := writeRoutingTable(t, `{
+		"routes": [{"model": "m", "upstreams": [
+			{"name": "a", "url": "http://a", "weight": 1, "timeout": "2s"}
+		]}]
+	}`)
+	router, err := NewRouter(path)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+
+	upstream, err := router.Select("m")
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if time.Duration(upstream.Timeout) != 2*time.Second {
+		t.Fatalf("expected a 2s timeout, got %s", time.Duration(upstream.Timeout))
+	}
+}
+
+func writeRoutingTable(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("write routing table: %v", err)
+	}
+	return path
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	streamTTFB = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gateway_stream_ttfb_seconds",
+		Help:    "Time to first byte for streamed chat responses",
+		Buckets: prometheus.DefBuckets,
+	})
+	streamTokensPerSecond = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gateway_stream_tokens_per_second",
+		Help:    "Observed tokens/sec for streamed chat responses",
+		Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(streamTTFB, streamTokensPerSecond)
+}
+
+// wantsStream reports whether the caller asked for a streaming response,
+// either via the standard SSE Accept header or a `"stream": true` field in
+// the JSON body.
+func wantsStream(r *http.Request, body []byte) bool {
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return true
+	}
+	var probe struct {
+		Stream bool `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &probe); err == nil && probe.Stream {
+		return true
+	}
+	return false
+}
+
+// streamProxy pipes the upstream SSE/chunked response to w as it arrives,
+// flushing after every read so tokens reach the client without being
+// buffered until completion. It cancels the upstream request if the client
+// disconnects, and records time-to-first-byte and tokens/sec metrics parsed
+// from `data:` frames.
+func streamProxy(w http.ResponseWriter, r *http.Request, proxyReq *http.Request, client *http.Client) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return streamProxyUnflushable(w, proxyReq, client)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	proxyReq = proxyReq.WithContext(ctx)
+
+	start := time.Now()
+	resp, err := client.Do(proxyReq)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "inference service unreachable"})
+		return err
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(resp.StatusCode)
+	flusher.Flush()
+
+	firstByte := true
+	tokenCount := 0
+	reader := bufio.NewReader(resp.Body)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if firstByte {
+				streamTTFB.Observe(time.Since(start).Seconds())
+				firstByte = false
+			}
+			tokenCount += countSSETokens(line)
+			if _, writeErr := w.Write(line); writeErr != nil {
+				return writeErr
+			}
+			flusher.Flush()
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+			return readErr
+		}
+	}
+
+	if elapsed := time.Since(start).Seconds(); elapsed > 0 && tokenCount > 0 {
+		streamTokensPerSecond.Observe(float64(tokenCount) / elapsed)
+	}
+	return nil
+}
+
+// streamProxyUnflushable falls back to a plain copy when the response
+// writer doesn't support flushing (e.g. in some test harnesses).
+func streamProxyUnflushable(w http.ResponseWriter, proxyReq *http.Request, client *http.Client) error {
+	resp, err := client.Do(proxyReq)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "inference service unreachable"})
+		return err
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// countSSETokens does a rough token count on a single SSE line by counting
+// whitespace-separated fields in its `data:` payload. This is a cheap proxy
+// for tokens/sec telemetry, not an exact tokenizer.
+func countSSETokens(line []byte) int {
+	trimmed := bytes.TrimSpace(line)
+	if !bytes.HasPrefix(trimmed, []byte("data:")) {
+		return 0
+	}
+	payload := bytes.TrimSpace(bytes.TrimPrefix(trimmed, []byte("data:")))
+	if len(payload) == 0 || bytes.Equal(payload, []byte("[DONE]")) {
+		return 0
+	}
+	return len(bytes.Fields(payload))
+}
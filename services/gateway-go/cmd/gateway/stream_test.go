@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWantsStreamAcceptHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	if !wantsStream(req, []byte(`{}`)) {
+		t.Fatalf("expected Accept: text/event-stream to trigger streaming")
+	}
+}
+
+func TestWantsStreamBodyField(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat", nil)
+	if !wantsStream(req, []byte(`{"stream": true}`)) {
+		t.Fatalf("expected stream:true body field to trigger streaming")
+	}
+	if wantsStream(req, []byte(`{"stream": false}`)) {
+		t.Fatalf("expected stream:false body field to not trigger streaming")
+	}
+}
+
+func TestStreamProxyFlushesChunks(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: {\"token\":\"tok%d\"}\n\n", i)
+			flusher.Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}))
+	defer upstream.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat", nil)
+	proxyReq, err := http.NewRequest(http.MethodPost, upstream.URL, nil)
+	if err != nil {
+		t.Fatalf("failed to build proxy request: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := streamProxy(rec, r, proxyReq, upstream.Client()); err != nil {
+		t.Fatalf("streamProxy returned error: %v", err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+	var frames int
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data:") {
+			frames++
+		}
+	}
+	if frames != 4 {
+		t.Fatalf("expected 4 SSE frames, got %d", frames)
+	}
+}
+
+func TestStreamProxyReturns502WhenUpstreamUnreachable(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upstreamURL := upstream.URL
+	upstream.Close() // nothing is listening on this URL anymore
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat", nil)
+	proxyReq, err := http.NewRequest(http.MethodPost, upstreamURL, nil)
+	if err != nil {
+		t.Fatalf("failed to build proxy request: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := streamProxy(rec, r, proxyReq, upstream.Client()); err == nil {
+		t.Fatalf("expected streamProxy to return the connection error")
+	}
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 when the upstream is unreachable, got %d", rec.Code)
+	}
+}
+
+func TestCountSSETokens(t *testing.T) {
+	if got := countSSETokens([]byte("data: one two three\n")); got != 3 {
+		t.Fatalf("expected 3 tokens, got %d", got)
+	}
+	if got := countSSETokens([]byte("data: [DONE]\n")); got != 0 {
+		t.Fatalf("expected 0 tokens for [DONE], got %d", got)
+	}
+	if got := countSSETokens([]byte("event: ping\n")); got != 0 {
+		t.Fatalf("expected 0 tokens for non-data line, got %d", got)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "gateway-go"
+
+// tracing holds the wiring set up by initTracing, plus enough state for the
+// health endpoint to report whether a real exporter is attached.
+type tracing struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	endpoint string
+	shutdown func(context.Context) error
+}
+
+// initTracing sets up a tracer provider exporting spans via OTLP/HTTP when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set. With no endpoint configured, spans are
+// still created (so context propagation and log correlation keep working)
+// but are never exported, so local runs don't need a collector.
+func initTracing(ctx context.Context, cfg config) (*tracing, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String("gateway"),
+		semconv.ServiceVersionKey.String(appVersion),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []sdktrace.TracerProviderOption
+	shutdown := func(context.Context) error { return nil }
+
+	if cfg.OTelEndpoint != "" {
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(cfg.OTelEndpoint))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+		shutdown = exporter.Shutdown
+	}
+	opts = append(opts, sdktrace.WithResource(res))
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return &tracing{
+		provider: provider,
+		tracer:   provider.Tracer(tracerName),
+		endpoint: cfg.OTelEndpoint,
+		shutdown: shutdown,
+	}, nil
+}
+
+// status reports whether a real OTLP exporter is attached, for the health
+// endpoint.
+func (t *tracing) status() map[string]any {
+	if t == nil {
+		return map[string]any{"enabled": false}
+	}
+	return map[string]any{
+		"enabled":  t.endpoint != "",
+		"endpoint": t.endpoint,
+	}
+}
+
+func (t *tracing) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.shutdown(ctx)
+}
+
+// wrapHandler instruments the whole mux with otelhttp, which extracts an
+// incoming W3C traceparent/tracestate if present or otherwise starts a new
+// root span.
+func (t *tracing) wrapHandler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "gateway.request")
+}
+
+// instrumentClient wraps an outbound *http.Client's transport so the
+// current span context is injected into upstream requests as
+// traceparent/tracestate headers.
+func instrumentClient(client *http.Client) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = otelhttp.NewTransport(base)
+}
+
+// newLogger returns a structured slog.Logger whose JSON output is
+// automatically enriched with trace_id/span_id when the log call's context
+// carries an active span.
+func newLogger() *slog.Logger {
+	return slog.New(traceHandler{Handler: slog.NewJSONHandler(os.Stdout, nil)})
+}
+
+// traceHandler decorates another slog.Handler with trace_id/span_id
+// attributes pulled from the record's context, so every log line can be
+// correlated with the span that produced it without each call site having
+// to thread that through manually.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, record slog.Record) error {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// annotateSpan attaches request-level attributes (tenant, subject, model,
+// token counts) to the active span, parsed from the request body or the
+// verified claims where available.
+func annotateSpan(ctx context.Context, claims *Claims, model string, tokens int) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	if claims != nil {
+		span.SetAttributes(
+			attribute.String("gateway.subject", claims.Subject),
+			attribute.String("gateway.tenant", claims.Tenant),
+		)
+	}
+	if model != "" {
+		span.SetAttributes(attribute.String("gateway.model", model))
+	}
+	if tokens > 0 {
+		span.SetAttributes(attribute.Int("gateway.tokens", tokens))
+	}
+}
+
+// requestModel extracts the `model` field from a chat request body, if
+// present, purely for span/log annotation.
+func requestModel(body []byte) string {
+	var probe struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return ""
+	}
+	return probe.Model
+}
+
+// responseTokens extracts a total token count from a chat response body in
+// the common `{"usage": {"total_tokens": N}}` shape, if present.
+func responseTokens(body []byte) int {
+	var probe struct {
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return 0
+	}
+	return probe.Usage.TotalTokens
+}
+
+// shutdownTimeout bounds how long the server waits for the tracer provider
+// to flush on shutdown.
+const shutdownTimeout = 5 * time.Second
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestRequestModel(t *testing.T) {
+	if got := requestModel([]byte(`{"model":"gpt-oss-20b"}`)); got != "gpt-oss-20b" {
+		t.Fatalf("unexpected model: %q", got)
+	}
+	if got := requestModel([]byte(`not json`)); got != "" {
+		t.Fatalf("expected empty model for invalid json, got %q", got)
+	}
+}
+
+func TestResponseTokens(t *testing.T) {
+	if got := responseTokens([]byte(`{"usage":{"total_tokens":42}}`)); got != 42 {
+		t.Fatalf("expected 42 tokens, got %d", got)
+	}
+	if got := responseTokens([]byte(`{}`)); got != 0 {
+		t.Fatalf("expected 0 tokens when usage missing, got %d", got)
+	}
+}
+
+func TestTraceHandlerAddsTraceAndSpanID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(traceHandler{Handler: slog.NewJSONHandler(&buf, nil)})
+
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+	ctx, span := provider.Tracer("test").Start(context.Background(), "unit-test")
+	defer span.End()
+
+	logger.InfoContext(ctx, "hello")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("unmarshal log line: %v", err)
+	}
+	if _, ok := entry["trace_id"]; !ok {
+		t.Fatalf("expected trace_id in log entry: %s", buf.String())
+	}
+	if _, ok := entry["span_id"]; !ok {
+		t.Fatalf("expected span_id in log entry: %s", buf.String())
+	}
+}
+
+func TestTraceHandlerOmitsIDsWithoutSpan(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(traceHandler{Handler: slog.NewJSONHandler(&buf, nil)})
+	logger.InfoContext(context.Background(), "hello")
+
+	if strings.Contains(buf.String(), "trace_id") {
+		t.Fatalf("expected no trace_id without an active span: %s", buf.String())
+	}
+}
+
+func TestTracingStatusNilIsDisabled(t *testing.T) {
+	var tel *tracing
+	status := tel.status()
+	if status["enabled"] != false {
+		t.Fatalf("expected tracing to report disabled when nil, got %+v", status)
+	}
+}